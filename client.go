@@ -0,0 +1,312 @@
+// Copyright 2013 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrBadHandshake is returned when the server response to opening handshake is
+// invalid.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// A Dialer contains options for connecting to a WebSocket server.
+type Dialer struct {
+	// NetDial specifies the dial function for creating the underlying
+	// network connection. If NetDial is nil, net.Dial is used.
+	NetDial func(network, addr string) (net.Conn, error)
+
+	// Proxy specifies a function to return a proxy for a given request. If
+	// Proxy is nil or returns a nil *url.URL, no proxy is used.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// TLSClientConfig specifies the TLS configuration to use with tls.Client.
+	// If nil, the default configuration is used.
+	TLSClientConfig *tls.Config
+
+	// HandshakeTimeout specifies the duration for the handshake to complete.
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize specify I/O buffer sizes in bytes.
+	// If a buffer size is zero, then a useful default size is used. The
+	// I/O buffer sizes do not limit the size of the messages that can be
+	// sent or received.
+	ReadBufferSize, WriteBufferSize int
+
+	// Subprotocols specifies the client's requested subprotocols.
+	Subprotocols []string
+
+	// EnableCompression specifies if the client should attempt to negotiate
+	// per message compression (RFC 7692).
+	EnableCompression bool
+}
+
+// DefaultDialer is a dialer with all fields set to the default values.
+var DefaultDialer = &Dialer{
+	Proxy:            http.ProxyFromEnvironment,
+	HandshakeTimeout: 45 * time.Second,
+}
+
+var errMalformedURL = errors.New("websocket: malformed ws or wss URL")
+
+func hostPortNoPort(u *url.URL) (hostPort, hostNoPort string) {
+	hostPort = u.Host
+	hostNoPort = u.Host
+	if i := strings.LastIndex(u.Host, ":"); i > strings.LastIndex(u.Host, "]") {
+		hostNoPort = hostNoPort[:i]
+	} else {
+		switch u.Scheme {
+		case "wss":
+			hostPort += ":443"
+		default:
+			hostPort += ":80"
+		}
+	}
+	return hostPort, hostNoPort
+}
+
+// Dial creates a new client connection. The urlStr argument must be a
+// WebSocket URL with a scheme of "ws" or "wss".
+//
+// The client is responsible for closing the connection. Dial returns the
+// server's HTTP response so that the caller can inspect the status and
+// headers when the handshake fails.
+func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return nil, nil, errMalformedURL
+	}
+
+	if u.User != nil {
+		return nil, nil, errMalformedURL
+	}
+
+	req := &http.Request{
+		Method:     "GET",
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+
+	for k, vs := range requestHeader {
+		switch {
+		case k == "Host":
+			if len(vs) > 0 {
+				req.Host = vs[0]
+			}
+		case k == "Upgrade" || k == "Connection" || k == "Sec-Websocket-Key" ||
+			k == "Sec-Websocket-Version" || k == "Sec-Websocket-Extensions" ||
+			(k == "Sec-Websocket-Protocol" && len(d.Subprotocols) > 0):
+			return nil, nil, errors.New("websocket: duplicate header not allowed: " + k)
+		default:
+			req.Header[k] = vs
+		}
+	}
+
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-Websocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+
+	challengeKey, err := generateChallengeKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-Websocket-Key", challengeKey)
+	req.Header.Set("Sec-Websocket-Version", "13")
+	if d.EnableCompression {
+		req.Header.Set("Sec-Websocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	hostPort, hostNoPort := hostPortNoPort(u)
+
+	var deadline time.Time
+	if d.HandshakeTimeout != 0 {
+		deadline = time.Now().Add(d.HandshakeTimeout)
+	}
+
+	netDial := d.NetDial
+	if netDial == nil {
+		netDialer := &net.Dialer{Deadline: deadline}
+		netDial = netDialer.Dial
+	}
+
+	var proxyURL *url.URL
+	if proxy := d.Proxy; proxy != nil {
+		proxyURL, err = proxy(req)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	dialAddr := hostPort
+	if proxyURL != nil {
+		dialAddr = hostPortNoPortFromURL(proxyURL)
+	}
+
+	netConn, err := netDial("tcp", dialAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if netConn == nil {
+		panic("websocket: nil netConn")
+	}
+
+	defer func() {
+		if netConn != nil {
+			netConn.Close()
+		}
+	}()
+
+	if !deadline.IsZero() {
+		netConn.SetDeadline(deadline)
+	}
+
+	if proxyURL != nil {
+		if err := connectProxy(netConn, proxyURL, hostPort); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if u.Scheme == "https" {
+		cfg := cloneTLSConfig(d.TLSClientConfig)
+		if cfg.ServerName == "" {
+			cfg.ServerName = hostNoPort
+		}
+		tlsConn := tls.Client(netConn, cfg)
+		netConn = tlsConn
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, nil, err
+		}
+		if !cfg.InsecureSkipVerify {
+			if err := tlsConn.VerifyHostname(cfg.ServerName); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	conn := newConn(netConn, false, d.ReadBufferSize, d.WriteBufferSize)
+
+	if err := req.Write(netConn); err != nil {
+		return nil, nil, err
+	}
+
+	// Read the handshake response through conn's own buffered reader rather
+	// than a throwaway one: the server may pipeline the first WebSocket
+	// frame immediately behind the 101 response in the same TCP segment,
+	// and conn.br is what ReadMessage will read from afterwards.
+	resp, err := http.ReadResponse(conn.br, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != 101 ||
+		!strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		!strings.EqualFold(resp.Header.Get("Connection"), "upgrade") ||
+		resp.Header.Get("Sec-Websocket-Accept") != computeAcceptKey(challengeKey) {
+		return nil, resp, ErrBadHandshake
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(nil))
+
+	if opts, _, ok := negotiateCompression(parseExtensions(resp.Header), d.EnableCompression); ok {
+		conn.newDecompressionReader = decompressNoContextTakeover
+		conn.newCompressionWriter = func(w io.WriteCloser) io.WriteCloser {
+			return compressNoContextTakeover(w, conn.compressionLevel)
+		}
+		conn.compressionLevel = defaultCompressionLevel
+		conn.serverNoContextTakeover = opts.serverNoContextTakeover
+		conn.clientNoContextTakeover = opts.clientNoContextTakeover
+	}
+
+	conn.subprotocol = resp.Header.Get("Sec-Websocket-Protocol")
+
+	netConn.SetDeadline(time.Time{})
+	netConn = nil // success, prevent the deferred close
+
+	return conn, resp, nil
+}
+
+func hostPortNoPortFromURL(u *url.URL) string {
+	hostPort, _ := hostPortNoPort(u)
+	return hostPort
+}
+
+// connectProxy issues an HTTP CONNECT request over conn to establish a
+// tunnel to targetHostPort through an HTTP proxy, as described by proxyURL.
+func connectProxy(conn net.Conn, proxyURL *url.URL, targetHostPort string) error {
+	connectReq := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: targetHostPort},
+		Host:   targetHostPort,
+		Header: make(http.Header),
+	}
+	if u := proxyURL.User; u != nil {
+		connectReq.Header.Set("Proxy-Authorization", "Basic "+basicAuth(u))
+	}
+	if err := connectReq.Write(conn); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, connectReq)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("websocket: proxy CONNECT failed: " + resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return errors.New("websocket: proxy sent data before CONNECT tunnel was established")
+	}
+	return nil
+}
+
+func basicAuth(u *url.Userinfo) string {
+	password, _ := u.Password()
+	return base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+}
+
+func cloneTLSConfig(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		return &tls.Config{}
+	}
+	return cfg.Clone()
+}
+
+// generateChallengeKey returns a new random Sec-WebSocket-Key value as
+// described in RFC 6455 Section 4.1.
+func generateChallengeKey() (string, error) {
+	p := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, p); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(p), nil
+}
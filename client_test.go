@@ -0,0 +1,67 @@
+// Copyright 2016 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDialUpgradeRoundTrip(t *testing.T) {
+	upgrader := Upgrader{Subprotocols: []string{"chat"}}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("server Upgrade: %v", err)
+			return
+		}
+		defer c.Close()
+
+		mt, p, err := c.ReadMessage()
+		if err != nil {
+			t.Errorf("server ReadMessage: %v", err)
+			return
+		}
+		if err := c.WriteMessage(mt, p); err != nil {
+			t.Errorf("server WriteMessage: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	d := &Dialer{Subprotocols: []string{"chat"}}
+	conn, resp, err := d.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	defer resp.Body.Close()
+
+	if conn.Subprotocol() != "chat" {
+		t.Fatalf("negotiated subprotocol = %q, want %q", conn.Subprotocol(), "chat")
+	}
+
+	if err := conn.WriteMessage(TextMessage, []byte("ping-pong")); err != nil {
+		t.Fatalf("client WriteMessage: %v", err)
+	}
+	mt, p, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("client ReadMessage: %v", err)
+	}
+	if mt != TextMessage || string(p) != "ping-pong" {
+		t.Fatalf("got (%d, %q), want (%d, %q)", mt, p, TextMessage, "ping-pong")
+	}
+}
+
+func TestDialRejectsNonWebSocketScheme(t *testing.T) {
+	d := &Dialer{}
+	if _, _, err := d.Dial("http://example.com", nil); err != errMalformedURL {
+		t.Fatalf("Dial with http:// scheme: err = %v, want %v", err, errMalformedURL)
+	}
+}
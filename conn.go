@@ -0,0 +1,439 @@
+// Copyright 2013 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The message types are defined in RFC 6455, Section 11.8.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+const (
+	continuationFrame = 0x0
+	textFrame         = 0x1
+	binaryFrame       = 0x2
+	closeFrame        = 0x8
+	pingFrame         = 0x9
+	pongFrame         = 0xa
+
+	finalBit = 1 << 7
+	rsv1Bit  = 1 << 6
+
+	maxControlFramePayloadSize = 125
+
+	defaultReadBufferSize  = 4096
+	defaultWriteBufferSize = 4096
+
+	// defaultMaxMessageSize bounds the frame payload length readFrame will
+	// allocate for, absent an application-chosen limit set via
+	// SetReadLimit. It exists so that a peer cannot force an unbounded
+	// allocation by claiming an enormous payload length.
+	defaultMaxMessageSize = 32 * 1024 * 1024
+)
+
+var (
+	errWriteClosed         = errors.New("websocket: write closed")
+	errBadWriteOpCode      = errors.New("websocket: bad write message type")
+	errInvalidControlFrame = errors.New("websocket: invalid control frame")
+	errFrameTooLarge       = errors.New("websocket: frame payload length invalid or exceeds read limit")
+
+	// ErrCloseSent is returned by WriteMessage and WriteControl methods when
+	// a close message was already sent on the connection.
+	ErrCloseSent = errors.New("websocket: close sent")
+)
+
+// CloseError describes a close message received from the peer.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return "websocket: close " + strconv.Itoa(e.Code) + " " + e.Text
+}
+
+const keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// computeAcceptKey returns the value of the Sec-WebSocket-Accept header
+// given the value of the client's Sec-WebSocket-Key header, as described in
+// RFC 6455 Section 4.2.2.
+func computeAcceptKey(challengeKey string) string {
+	h := sha1.New()
+	h.Write([]byte(challengeKey))
+	h.Write([]byte(keyGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Conn represents a WebSocket connection.
+type Conn struct {
+	conn     net.Conn
+	isServer bool
+
+	subprotocol string
+
+	br *bufio.Reader
+
+	writeMu  sync.Mutex
+	writeBuf []byte
+
+	readMu    sync.Mutex
+	closeSent bool
+	readLimit int64
+
+	// Compression. newDecompressionReader and newCompressionWriter are set
+	// by Upgrade/Dial when the peer negotiates permessage-deflate;
+	// enableWriteCompression lets the application disable compression for
+	// an individual message. serverNoContextTakeover/clientNoContextTakeover
+	// record what was negotiated; this implementation always negotiates
+	// "no context takeover" on both sides (see negotiateCompression), so a
+	// fresh flate stream is used for every message.
+	newDecompressionReader  func(io.Reader) io.ReadCloser
+	newCompressionWriter    func(io.WriteCloser) io.WriteCloser
+	compressionLevel        int
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+	enableWriteCompression  bool
+}
+
+// newConn allocates a Conn wrapping conn. isServer selects the masking rules
+// required by RFC 6455 Section 5: a server never masks outgoing frames and
+// requires all frames it reads to be masked; a client does the opposite.
+func newConn(conn net.Conn, isServer bool, readBufferSize, writeBufferSize int) *Conn {
+	if readBufferSize <= 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	if writeBufferSize <= 0 {
+		writeBufferSize = defaultWriteBufferSize
+	}
+	return &Conn{
+		conn:                   conn,
+		isServer:               isServer,
+		br:                     bufio.NewReaderSize(conn, readBufferSize),
+		writeBuf:               make([]byte, 0, writeBufferSize),
+		compressionLevel:       defaultCompressionLevel,
+		enableWriteCompression: true,
+		readLimit:              defaultMaxMessageSize,
+	}
+}
+
+// Subprotocol returns the negotiated protocol for the connection.
+func (c *Conn) Subprotocol() string { return c.subprotocol }
+
+// Close closes the underlying network connection without sending or
+// waiting for a close message.
+func (c *Conn) Close() error { return c.conn.Close() }
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetReadDeadline sets the read deadline on the underlying network
+// connection.
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+// SetWriteDeadline sets the write deadline on the underlying network
+// connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// SetCompressionLevel sets the flate compression level used for subsequent
+// messages, in the range accepted by compress/flate (flate.HuffmanOnly to
+// flate.BestCompression). It has no effect unless the peer negotiated
+// permessage-deflate during the handshake.
+func (c *Conn) SetCompressionLevel(level int) error {
+	if !isValidCompressionLevel(level) {
+		return errors.New("websocket: invalid compression level")
+	}
+	c.compressionLevel = level
+	return nil
+}
+
+// SetReadLimit sets the maximum size, in bytes, of a single frame payload
+// that ReadMessage will allocate for. Frames claiming a larger payload are
+// rejected without being read. A limit <= 0 disables the cap (a malformed
+// or negative length is still always rejected).
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// EnableWriteCompression enables or disables compression of subsequent
+// messages written with WriteMessage. Compression is only applied when the
+// peer negotiated permessage-deflate during the handshake; this method lets
+// the application opt individual messages out (or back in) of compression,
+// e.g. because a message is already compressed application data.
+func (c *Conn) EnableWriteCompression(enable bool) {
+	c.enableWriteCompression = enable
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// WriteMessage writes a message with the given type (TextMessage,
+// BinaryMessage, CloseMessage, PingMessage or PongMessage) and payload.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case TextMessage, BinaryMessage:
+	case CloseMessage, PingMessage, PongMessage:
+		if len(data) > maxControlFramePayloadSize {
+			return errInvalidControlFrame
+		}
+	default:
+		return errBadWriteOpCode
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if messageType == CloseMessage {
+		c.closeSent = true
+	}
+
+	payload := data
+	rsv1 := false
+	if (messageType == TextMessage || messageType == BinaryMessage) &&
+		c.newCompressionWriter != nil && c.enableWriteCompression {
+		var buf bytes.Buffer
+		cw := c.newCompressionWriter(nopWriteCloser{&buf})
+		if _, err := cw.Write(data); err != nil {
+			cw.Close()
+			return err
+		}
+		if err := cw.Close(); err != nil {
+			return err
+		}
+		payload = buf.Bytes()
+		rsv1 = true
+	}
+
+	return c.writeFrame(messageType, payload, rsv1)
+}
+
+// writeControl writes a control frame. Like WriteMessage, it takes writeMu,
+// so that the pong and close replies ReadMessage sends while holding readMu
+// do not interleave with frames written by WriteMessage.
+func (c *Conn) writeControl(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == closeFrame {
+		c.closeSent = true
+	}
+	return c.writeFrame(opcode, payload, false)
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte, rsv1 bool) error {
+	var header [14]byte
+	header[0] = byte(opcode) | finalBit
+	if rsv1 {
+		header[0] |= rsv1Bit
+	}
+	n := 1
+
+	var maskBit byte
+	if !c.isServer {
+		maskBit = 1 << 7
+	}
+
+	length := len(payload)
+	switch {
+	case length <= 125:
+		header[1] = byte(length) | maskBit
+		n++
+	case length <= 65535:
+		header[1] = 126 | maskBit
+		binary.BigEndian.PutUint16(header[2:4], uint16(length))
+		n += 3
+	default:
+		header[1] = 127 | maskBit
+		binary.BigEndian.PutUint64(header[2:10], uint64(length))
+		n += 9
+	}
+
+	var key [4]byte
+	if !c.isServer {
+		if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+			return err
+		}
+		n += copy(header[n:], key[:])
+	}
+
+	if _, err := c.conn.Write(header[:n]); err != nil {
+		return err
+	}
+
+	if length == 0 {
+		return nil
+	}
+
+	if !c.isServer {
+		masked := make([]byte, length)
+		copy(masked, payload)
+		maskBytes(key, 0, masked)
+		payload = masked
+	}
+
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// ReadMessage reads the next data message (TextMessage or BinaryMessage)
+// from the connection, reassembling fragmented messages and transparently
+// handling control frames: ping frames are answered with a pong, pong
+// frames are discarded, and a close frame is echoed back and returned as a
+// *CloseError.
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	var payload []byte
+	compressed := false
+	first := true
+
+	for {
+		fin, opcode, rsv1, framePayload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case pingFrame:
+			if err := c.writeControl(pongFrame, framePayload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case pongFrame:
+			continue
+		case closeFrame:
+			code, text := parseClosePayload(framePayload)
+			c.writeControl(closeFrame, framePayload)
+			return 0, nil, &CloseError{Code: code, Text: text}
+		}
+
+		if first {
+			messageType = opcode
+			compressed = rsv1
+			first = false
+		}
+		payload = append(payload, framePayload...)
+
+		if fin {
+			break
+		}
+	}
+
+	if compressed {
+		r := c.newDecompressionReader(bytes.NewReader(payload))
+		defer r.Close()
+		payload, err = io.ReadAll(r)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return messageType, payload, nil
+}
+
+func (c *Conn) readFrame() (fin bool, opcode int, rsv1 bool, payload []byte, err error) {
+	b0, err := c.br.ReadByte()
+	if err != nil {
+		return false, 0, false, nil, err
+	}
+	fin = b0&finalBit != 0
+	rsv1 = b0&rsv1Bit != 0
+	opcode = int(b0 & 0xf)
+
+	b1, err := c.br.ReadByte()
+	if err != nil {
+		return false, 0, false, nil, err
+	}
+	masked := b1&0x80 != 0
+	length := int64(b1 & 0x7f)
+
+	switch length {
+	case 126:
+		var buf [2]byte
+		if _, err = io.ReadFull(c.br, buf[:]); err != nil {
+			return false, 0, false, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(buf[:]))
+	case 127:
+		var buf [8]byte
+		if _, err = io.ReadFull(c.br, buf[:]); err != nil {
+			return false, 0, false, nil, err
+		}
+		// The top bit of a 64-bit length can make this cast negative; that,
+		// and any length beyond the configured read limit, must be
+		// rejected before it reaches make([]byte, length) below.
+		length = int64(binary.BigEndian.Uint64(buf[:]))
+	}
+
+	if length < 0 || (c.readLimit > 0 && length > c.readLimit) {
+		return false, 0, false, nil, errFrameTooLarge
+	}
+
+	// RFC 6455 Section 5.5: control frames are never fragmented and never
+	// carry more than 125 bytes of payload.
+	if opcode == closeFrame || opcode == pingFrame || opcode == pongFrame {
+		if !fin || length > maxControlFramePayloadSize {
+			return false, 0, false, nil, errInvalidControlFrame
+		}
+	}
+
+	// Servers must reject unmasked frames; clients must reject masked ones
+	// (RFC 6455 Section 5.1).
+	if masked != c.isServer {
+		return false, 0, false, nil, errors.New("websocket: incorrect frame masking")
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, key[:]); err != nil {
+			return false, 0, false, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return false, 0, false, nil, err
+	}
+
+	if masked {
+		maskBytes(key, 0, payload)
+	}
+
+	return fin, opcode, rsv1, payload, nil
+}
+
+func parseClosePayload(payload []byte) (code int, text string) {
+	if len(payload) < 2 {
+		return 1005, ""
+	}
+	return int(binary.BigEndian.Uint16(payload[:2])), string(payload[2:])
+}
@@ -0,0 +1,215 @@
+// Copyright 2017 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestConnReadWriteMessageRoundTrip(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	sc := newConn(server, true, 0, 0)
+	cc := newConn(client, false, 0, 0)
+
+	done := make(chan error, 1)
+	go func() { done <- cc.WriteMessage(TextMessage, []byte("hello")) }()
+
+	mt, p, err := sc.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if mt != TextMessage {
+		t.Fatalf("message type = %d, want %d", mt, TextMessage)
+	}
+	if string(p) != "hello" {
+		t.Fatalf("payload = %q, want %q", p, "hello")
+	}
+}
+
+func TestConnRespondsToPingWithPong(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	sc := newConn(server, true, 0, 0)
+	cc := newConn(client, false, 0, 0)
+
+	go func() { _ = cc.writeFrame(pingFrame, nil, false) }()
+	go func() { _, _, _ = sc.ReadMessage() }()
+
+	_, opcode, _, _, err := cc.readFrame()
+	if err != nil {
+		t.Fatalf("readFrame: %v", err)
+	}
+	if opcode != pongFrame {
+		t.Fatalf("opcode = %d, want pong (%d)", opcode, pongFrame)
+	}
+}
+
+func TestConnCompressedRoundTrip(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	sc := newConn(server, true, 0, 0)
+	cc := newConn(client, false, 0, 0)
+
+	for _, c := range []*Conn{sc, cc} {
+		c.newDecompressionReader = decompressNoContextTakeover
+		c.newCompressionWriter = func(w io.WriteCloser) io.WriteCloser {
+			return compressNoContextTakeover(w, defaultCompressionLevel)
+		}
+	}
+
+	const msg = "compressed round trip payload, repeated repeated repeated repeated"
+	done := make(chan error, 1)
+	go func() { done <- cc.WriteMessage(TextMessage, []byte(msg)) }()
+
+	_, p, err := sc.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if string(p) != msg {
+		t.Fatalf("payload = %q, want %q", p, msg)
+	}
+}
+
+func TestCompressDecompressRoundTrip(t *testing.T) {
+	// Long enough (at least 128 bytes) that compress/flate's BestSpeed level
+	// looks for repeated strings instead of only Huffman-coding the input.
+	const msg = "the quick brown fox jumps over the lazy dog, the quick brown fox jumps over the lazy dog, the quick brown fox jumps over the lazy dog"
+
+	var buf bytes.Buffer
+	cw := compressNoContextTakeover(nopWriteCloser{&buf}, defaultCompressionLevel)
+	if _, err := cw.Write([]byte(msg)); err != nil {
+		t.Fatalf("compress write: %v", err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatalf("compress close: %v", err)
+	}
+
+	if buf.Len() >= len(msg) {
+		t.Fatalf("expected the repetitive message to compress smaller, got %d bytes for a %d byte input", buf.Len(), len(msg))
+	}
+
+	r := decompressNoContextTakeover(bytes.NewReader(buf.Bytes()))
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if string(got) != msg {
+		t.Fatalf("round trip mismatch: got %q, want %q", got, msg)
+	}
+}
+
+func TestReadFrameRejectsOversizedLength(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	sc := newConn(server, true, 0, 0)
+
+	// A 64-bit length with the top bit set casts to a negative int64 if
+	// read naively, which previously reached make([]byte, length) and
+	// panicked.
+	raw := []byte{
+		0x81,                                           // FIN, text frame
+		0xFF,                                           // masked, length = 127 (extended 64-bit length follows)
+		0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // top bit set
+	}
+	go func() { _, _ = client.Write(raw) }()
+
+	if _, _, err := sc.ReadMessage(); err == nil {
+		t.Fatal("expected ReadMessage to reject a negative/oversized frame length, got nil error")
+	}
+}
+
+func TestSetReadLimitRejectsLargeFrame(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	sc := newConn(server, true, 0, 0)
+	sc.SetReadLimit(10)
+	cc := newConn(client, false, 0, 0)
+
+	go func() { _ = cc.writeFrame(textFrame, make([]byte, 20), false) }()
+
+	if _, _, err := sc.ReadMessage(); err == nil {
+		t.Fatal("expected ReadMessage to reject a frame payload larger than the configured read limit")
+	}
+}
+
+func TestReadFrameRejectsFragmentedControlFrame(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	sc := newConn(server, true, 0, 0)
+
+	raw := []byte{
+		0x09, // no FIN bit: control frames must never be fragmented
+		0x85, // masked, length = 5
+	}
+	go func() { _, _ = client.Write(raw) }()
+
+	if _, _, err := sc.ReadMessage(); err == nil {
+		t.Fatal("expected ReadMessage to reject a fragmented control frame")
+	}
+}
+
+func TestReadFrameRejectsOversizedControlFrame(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	sc := newConn(server, true, 0, 0)
+
+	raw := []byte{
+		0x89,       // FIN, ping
+		0xFE,       // masked, length = 126 (extended 16-bit length follows)
+		0x00, 0xC8, // 200 bytes, over the 125 byte control frame limit
+	}
+	go func() { _, _ = client.Write(raw) }()
+
+	if _, _, err := sc.ReadMessage(); err == nil {
+		t.Fatal("expected ReadMessage to reject an oversized control frame")
+	}
+}
+
+func TestNegotiateCompressionAlwaysNoContextTakeover(t *testing.T) {
+	exts := parseExtensions(http.Header{
+		"Sec-Websocket-Extensions": {"permessage-deflate; client_max_window_bits"},
+	})
+
+	opts, response, ok := negotiateCompression(exts, true)
+	if !ok {
+		t.Fatal("expected permessage-deflate to be accepted")
+	}
+	if !opts.serverNoContextTakeover || !opts.clientNoContextTakeover {
+		t.Fatalf("expected no_context_takeover on both sides, got %+v", opts)
+	}
+	if response != "permessage-deflate; server_no_context_takeover; client_no_context_takeover" {
+		t.Fatalf("unexpected response extension: %q", response)
+	}
+
+	if _, _, ok := negotiateCompression(exts, false); ok {
+		t.Fatal("expected compression to be rejected when disabled")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"strings"
+)
+
+// parseExtensions parses the Sec-WebSocket-Extensions header value(s) into a
+// slice of extension descriptions. Each extension is represented as a map
+// from parameter name to parameter value; the extension token itself is
+// stored under the empty string key. Malformed extensions are skipped.
+//
+// See RFC 6455 Section 9.1 for the extension-list grammar.
+func parseExtensions(header http.Header) []map[string]string {
+	var result []map[string]string
+	for _, s := range header["Sec-Websocket-Extensions"] {
+	parts:
+		for _, part := range strings.Split(s, ",") {
+			fields := strings.Split(part, ";")
+			token := strings.TrimSpace(fields[0])
+			if token == "" {
+				continue
+			}
+			ext := map[string]string{"": token}
+			for _, f := range fields[1:] {
+				f = strings.TrimSpace(f)
+				if f == "" {
+					continue parts
+				}
+				if i := strings.IndexByte(f, '='); i >= 0 {
+					k := strings.TrimSpace(f[:i])
+					v := strings.Trim(strings.TrimSpace(f[i+1:]), `"`)
+					ext[k] = v
+				} else {
+					ext[f] = ""
+				}
+			}
+			result = append(result, ext)
+		}
+	}
+	return result
+}
+
+const permessageDeflate = "permessage-deflate"
+
+// compressionOptions holds the permessage-deflate parameters negotiated
+// during the handshake, as described in RFC 7692.
+type compressionOptions struct {
+	// serverNoContextTakeover and clientNoContextTakeover record that the
+	// corresponding side resets its flate state between messages instead of
+	// reusing the compression dictionary across the connection.
+	//
+	// This implementation always negotiates "no context takeover" on both
+	// sides, regardless of what the peer offered: context takeover would
+	// require keeping a live *flate.Writer/Reader pinned to the connection
+	// instead of pooling them by compression level, which is a larger
+	// change than this handshake negotiation warrants. "No context
+	// takeover" is always a legal response to any permessage-deflate offer
+	// (RFC 7692 Section 7.1.1.1), so this is a conservative, interoperable
+	// choice rather than a partial implementation of the parameter.
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+}
+
+// negotiateCompression inspects the permessage-deflate offers in extensions
+// and, if compression is enabled, returns the options to use along with the
+// Sec-WebSocket-Extensions response value that accepts the offer. The
+// third return value is false if the peer did not offer permessage-deflate,
+// every offer asks for a non-default server_max_window_bits, or compression
+// is disabled.
+//
+// The compression level and the per-message enable/disable switch described
+// in the design are not handshake concerns: they are runtime knobs exposed
+// once the connection exists, via Conn.SetCompressionLevel and
+// Conn.EnableWriteCompression.
+func negotiateCompression(extensions []map[string]string, enabled bool) (compressionOptions, string, bool) {
+	if !enabled {
+		return compressionOptions{}, "", false
+	}
+	for _, ext := range extensions {
+		if ext[""] != permessageDeflate {
+			continue
+		}
+
+		// A server_max_window_bits value other than the default is not
+		// supported; reject offers that require a smaller server window by
+		// falling through to the next offer. client_max_window_bits is not
+		// checked: it only bounds the window the client compresses with,
+		// which the flate reader accepts at any size.
+		if v, ok := ext["server_max_window_bits"]; ok && v != "15" {
+			continue
+		}
+
+		opts := compressionOptions{
+			serverNoContextTakeover: true,
+			clientNoContextTakeover: true,
+		}
+
+		response := permessageDeflate + "; server_no_context_takeover; client_no_context_takeover"
+		return opts, response, true
+	}
+	return compressionOptions{}, "", false
+}
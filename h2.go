@@ -0,0 +1,126 @@
+// Copyright 2024 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// isExtendedConnect reports whether r is an HTTP/2 extended CONNECT request
+// for the "websocket" protocol, as described in RFC 8441.
+func isExtendedConnect(r *http.Request) bool {
+	return r.Method == http.MethodConnect && r.ProtoMajor >= 2 && r.Header.Get(":protocol") == "websocket"
+}
+
+// upgradeH2 upgrades an HTTP/2 extended CONNECT request to a WebSocket
+// connection. Unlike the HTTP/1.1 path, no http.Hijacker is required: both
+// directions of the connection are carried over the single HTTP/2 stream,
+// using http.ResponseController to flush writes and manage deadlines.
+func (u *Upgrader) upgradeH2(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if !tokenListContainsValue(r.Header, "Sec-Websocket-Version", "13") {
+		return u.error(w, r, http.StatusBadRequest, "websocket: unsupported version: 13 not found in 'Sec-Websocket-Version' header")
+	}
+
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(r) {
+		return u.error(w, r, http.StatusForbidden, "websocket: request origin not allowed by Upgrader.CheckOrigin")
+	}
+
+	if _, ok := responseHeader["Sec-Websocket-Extensions"]; ok {
+		return u.error(w, r, http.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported")
+	}
+
+	rc := http.NewResponseController(w)
+	subprotocol := u.selectSubprotocol(r)
+	if u.Subprotocols == nil && responseHeader != nil {
+		subprotocol = responseHeader.Get("Sec-Websocket-Protocol")
+	}
+	opts, extResponse, compress := negotiateCompression(parseExtensions(r.Header), u.EnableCompression)
+
+	for k, vs := range responseHeader {
+		if k == "Sec-Websocket-Protocol" {
+			continue
+		}
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	if compress {
+		w.Header().Set("Sec-Websocket-Extensions", extResponse)
+	}
+	if subprotocol != "" {
+		w.Header().Set("Sec-Websocket-Protocol", subprotocol)
+	}
+
+	// RFC 8441 Section 4: a successful extended CONNECT response carries a
+	// normal 2xx status; HTTP/2 has no 101 Switching Protocols.
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, err
+	}
+
+	netConn := &h2StreamConn{r: r, rc: rc, w: w}
+	c := newConn(netConn, true, u.ReadBufferSize, u.WriteBufferSize)
+
+	if compress {
+		c.newDecompressionReader = decompressNoContextTakeover
+		c.newCompressionWriter = func(wc io.WriteCloser) io.WriteCloser {
+			return compressNoContextTakeover(wc, c.compressionLevel)
+		}
+		c.compressionLevel = defaultCompressionLevel
+		c.serverNoContextTakeover = opts.serverNoContextTakeover
+		c.clientNoContextTakeover = opts.clientNoContextTakeover
+	}
+	c.subprotocol = subprotocol
+
+	return c, nil
+}
+
+// h2StreamConn adapts the request body reader and response writer of a
+// single HTTP/2 stream into a net.Conn, so that an extended CONNECT stream
+// can be wrapped by Conn the same way a Hijacked net.Conn is.
+type h2StreamConn struct {
+	r  *http.Request
+	rc *http.ResponseController
+	w  http.ResponseWriter
+}
+
+func (c *h2StreamConn) Read(p []byte) (int, error) { return c.r.Body.Read(p) }
+
+func (c *h2StreamConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.rc.Flush()
+}
+
+func (c *h2StreamConn) Close() error { return c.r.Body.Close() }
+
+func (c *h2StreamConn) LocalAddr() net.Addr  { return h2StreamAddr{} }
+func (c *h2StreamConn) RemoteAddr() net.Addr { return h2StreamAddr{} }
+
+func (c *h2StreamConn) SetDeadline(t time.Time) error {
+	if err := c.rc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.rc.SetWriteDeadline(t)
+}
+
+func (c *h2StreamConn) SetReadDeadline(t time.Time) error  { return c.rc.SetReadDeadline(t) }
+func (c *h2StreamConn) SetWriteDeadline(t time.Time) error { return c.rc.SetWriteDeadline(t) }
+
+// h2StreamAddr is a placeholder net.Addr for an HTTP/2 stream, which has no
+// underlying socket address of its own.
+type h2StreamAddr struct{}
+
+func (h2StreamAddr) Network() string { return "h2" }
+func (h2StreamAddr) String() string  { return "h2-extended-connect" }
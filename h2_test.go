@@ -0,0 +1,79 @@
+// Copyright 2024 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsExtendedConnect(t *testing.T) {
+	cases := []struct {
+		name string
+		req  *http.Request
+		want bool
+	}{
+		{
+			name: "http/2 extended connect for websocket",
+			req: &http.Request{
+				Method:     http.MethodConnect,
+				ProtoMajor: 2,
+				Header:     http.Header{":protocol": {"websocket"}},
+			},
+			want: true,
+		},
+		{
+			name: "http/1.1 upgrade is not extended connect",
+			req: &http.Request{
+				Method:     http.MethodGet,
+				ProtoMajor: 1,
+				Header:     http.Header{"Upgrade": {"websocket"}},
+			},
+			want: false,
+		},
+		{
+			name: "http/2 connect for a different protocol",
+			req: &http.Request{
+				Method:     http.MethodConnect,
+				ProtoMajor: 2,
+				Header:     http.Header{":protocol": {"something-else"}},
+			},
+			want: false,
+		},
+	}
+	for _, c := range cases {
+		if got := isExtendedConnect(c.req); got != c.want {
+			t.Errorf("%s: isExtendedConnect() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// TestH2StreamConnReadWrite exercises the net.Conn adapter directly against
+// an httptest.ResponseRecorder, since driving a real HTTP/2 extended
+// CONNECT handshake end-to-end would require a TLS-backed h2 server.
+func TestH2StreamConnReadWrite(t *testing.T) {
+	req := httptest.NewRequest(http.MethodConnect, "/", strings.NewReader("hello"))
+	rec := httptest.NewRecorder()
+	conn := &h2StreamConn{r: req, rc: http.NewResponseController(rec), w: rec}
+
+	buf := make([]byte, 5)
+	n, err := conn.Read(buf)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("Read = %q, want %q", buf[:n], "hello")
+	}
+
+	if _, err := conn.Write([]byte("world")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got := rec.Body.String(); got != "world" {
+		t.Fatalf("response body = %q, want %q", got, "world")
+	}
+}
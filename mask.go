@@ -0,0 +1,17 @@
+// Copyright 2013 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+// maskBytes applies the RFC 6455 Section 5.3 masking algorithm to b in
+// place, continuing from the given position in the 4-byte key (used when a
+// payload is masked or unmasked across multiple calls). It returns the
+// position to resume at for any subsequent call.
+func maskBytes(key [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= key[pos&3]
+		pos++
+	}
+	return pos & 3
+}
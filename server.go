@@ -5,10 +5,12 @@
 package websocket
 
 import (
-	"bufio"
 	"errors"
-	"net"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
 // HandshakeError describes an error with the handshake from the peer.
@@ -18,69 +20,192 @@ type HandshakeError struct {
 
 func (e HandshakeError) Error() string { return e.Err }
 
+// Upgrader specifies parameters for upgrading an HTTP connection to a
+// WebSocket connection.
+type Upgrader struct {
+	// HandshakeTimeout specifies the duration for the handshake to complete.
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize specify I/O buffer sizes in bytes.
+	// If a buffer size is zero, then a useful default size is used. The
+	// I/O buffer sizes do not limit the size of the messages that can be
+	// sent or received.
+	ReadBufferSize, WriteBufferSize int
+
+	// Subprotocols specifies the server's supported protocols in order of
+	// preference. If this field is not nil, Upgrade negotiates a
+	// subprotocol by selecting the first match in this list with a protocol
+	// requested by the client. If there's no match, then no protocol is
+	// negotiated (the Sec-Websocket-Protocol header is not included in the
+	// handshake response).
+	Subprotocols []string
+
+	// Error specifies the function for generating HTTP error responses. If
+	// Error is nil, then http.Error is used to generate the HTTP response.
+	Error func(w http.ResponseWriter, r *http.Request, status int, reason error)
+
+	// CheckOrigin returns true if the request Origin header is acceptable.
+	// If CheckOrigin is nil, then a safe default is used: return false if
+	// the Origin request header is present and the origin host is not equal
+	// to the Host request header.
+	CheckOrigin func(r *http.Request) bool
+
+	// EnableCompression specifies if the server should attempt to negotiate
+	// per message compression (RFC 7692). Setting this value to true does
+	// not guarantee that compression will be supported, as negotiation
+	// also depends on the peer offering the permessage-deflate extension.
+	EnableCompression bool
+}
+
+// checkSameOrigin returns true if the Origin request header is absent, or
+// if the origin's host matches the request's Host header.
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header["Origin"]
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin[0])
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func (u *Upgrader) selectSubprotocol(r *http.Request) string {
+	if u.Subprotocols == nil {
+		return ""
+	}
+	clientProtocols := Subprotocols(r)
+	for _, serverProtocol := range u.Subprotocols {
+		for _, clientProtocol := range clientProtocols {
+			if clientProtocol == serverProtocol {
+				return clientProtocol
+			}
+		}
+	}
+	return ""
+}
+
+func (u *Upgrader) error(w http.ResponseWriter, r *http.Request, status int, reason string) (*Conn, error) {
+	err := HandshakeError{reason}
+	if u.Error != nil {
+		u.Error(w, r, status, err)
+	} else {
+		w.Header().Set("Sec-Websocket-Version", "13")
+		http.Error(w, http.StatusText(status), status)
+	}
+	return nil, err
+}
+
 // Upgrade upgrades the HTTP server connection to the WebSocket protocol.
 //
 // Upgrade returns a HandshakeError if the request is not a WebSocket
-// handshake. Applications should handle errors of this type by replying to the
-// client with an HTTP response.
-//
-// The application is responsible for checking the request origin before
-// calling Upgrade. An example implementation of the same origin policy is:
+// handshake, after writing an HTTP error response (via Upgrader.Error, or
+// http.Error if Error is nil).
 //
-//	if req.Header.Get("Origin") != "http://"+req.Host {
-//		http.Error(w, "Origin not allowed", 403)
-//		return
-//	}
+// The responseHeader is used to specify cookies (Set-Cookie) and the
+// application specific subprotocol (Sec-WebSocket-Protocol) when
+// Upgrader.Subprotocols is not set. Upgrade returns an error if
+// responseHeader contains a Sec-WebSocket-Extensions header; if the
+// handshake negotiates permessage-deflate compression, Upgrade adds its own
+// Sec-WebSocket-Extensions header to the response.
 //
-// Use the responseHeader to specify cookies (Set-Cookie) and the subprotocol
-// (Sec-WebSocket-Protocol).
-func Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header, readBufSize, writeBufSize int) (*Conn, error) {
-
-	if values := r.Header["Sec-Websocket-Version"]; len(values) == 0 || values[0] != "13" {
-		return nil, HandshakeError{"websocket: version != 13"}
+// Upgrade also accepts an HTTP/2 extended CONNECT request for the
+// "websocket" protocol (RFC 8441). In that case, the connection is
+// established over the single HTTP/2 stream via http.ResponseController
+// instead of http.Hijacker.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if isExtendedConnect(r) {
+		return u.upgradeH2(w, r, responseHeader)
 	}
 
+	const badHandshake = "websocket: the client is not using the websocket protocol: "
+
 	if !tokenListContainsValue(r.Header, "Connection", "upgrade") {
-		return nil, HandshakeError{"websocket: connection header != upgrade"}
+		return u.error(w, r, http.StatusBadRequest, badHandshake+"'upgrade' token not found in 'Connection' header")
 	}
 
 	if !tokenListContainsValue(r.Header, "Upgrade", "websocket") {
-		return nil, HandshakeError{"websocket: upgrade != websocket"}
+		return u.error(w, r, http.StatusBadRequest, badHandshake+"'websocket' token not found in 'Upgrade' header")
+	}
+
+	if r.Method != "GET" {
+		return u.error(w, r, http.StatusMethodNotAllowed, badHandshake+"request method is not GET")
+	}
+
+	if !tokenListContainsValue(r.Header, "Sec-Websocket-Version", "13") {
+		return u.error(w, r, http.StatusBadRequest, "websocket: unsupported version: 13 not found in 'Sec-Websocket-Version' header")
 	}
 
-	var challengeKey string
-	values := r.Header["Sec-Websocket-Key"]
-	if len(values) == 0 || values[0] == "" {
-		return nil, HandshakeError{"websocket: key missing or blank"}
+	if _, ok := responseHeader["Sec-Websocket-Extensions"]; ok {
+		return u.error(w, r, http.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported")
 	}
-	challengeKey = values[0]
 
-	var (
-		netConn net.Conn
-		br      *bufio.Reader
-		err     error
-	)
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(r) {
+		return u.error(w, r, http.StatusForbidden, "websocket: request origin not allowed by Upgrader.CheckOrigin")
+	}
+
+	challengeKey := r.Header.Get("Sec-Websocket-Key")
+	if challengeKey == "" {
+		return u.error(w, r, http.StatusBadRequest, "websocket: not a websocket handshake: 'Sec-WebSocket-Key' header is missing or blank")
+	}
+
+	subprotocol := u.selectSubprotocol(r)
+	if u.Subprotocols == nil && responseHeader != nil {
+		subprotocol = responseHeader.Get("Sec-Websocket-Protocol")
+	}
+
+	opts, extResponse, compress := negotiateCompression(parseExtensions(r.Header), u.EnableCompression)
 
 	h, ok := w.(http.Hijacker)
 	if !ok {
-		return nil, errors.New("websocket: response does not implement http.Hijacker")
+		return u.error(w, r, http.StatusInternalServerError, "websocket: response does not implement http.Hijacker")
+	}
+	netConn, rw, err := h.Hijack()
+	if err != nil {
+		return u.error(w, r, http.StatusInternalServerError, err.Error())
 	}
-	var rw *bufio.ReadWriter
-	netConn, rw, err = h.Hijack()
-	br = rw.Reader
+	br := rw.Reader
 
 	if br.Buffered() > 0 {
 		netConn.Close()
 		return nil, errors.New("websocket: client sent data before handshake is complete")
 	}
 
-	c := newConn(netConn, true, readBufSize, writeBufSize)
+	c := newConn(netConn, true, u.ReadBufferSize, u.WriteBufferSize)
+
+	if compress {
+		c.newDecompressionReader = decompressNoContextTakeover
+		c.newCompressionWriter = func(w io.WriteCloser) io.WriteCloser {
+			return compressNoContextTakeover(w, c.compressionLevel)
+		}
+		c.compressionLevel = defaultCompressionLevel
+		c.serverNoContextTakeover = opts.serverNoContextTakeover
+		c.clientNoContextTakeover = opts.clientNoContextTakeover
+	}
 
 	p := c.writeBuf[:0]
 	p = append(p, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: "...)
 	p = append(p, computeAcceptKey(challengeKey)...)
 	p = append(p, "\r\n"...)
+	if compress {
+		p = append(p, "Sec-WebSocket-Extensions: "...)
+		p = append(p, extResponse...)
+		p = append(p, "\r\n"...)
+	}
+	if subprotocol != "" {
+		p = append(p, "Sec-WebSocket-Protocol: "...)
+		p = append(p, subprotocol...)
+		p = append(p, "\r\n"...)
+	}
 	for k, vs := range responseHeader {
+		if k == "Sec-Websocket-Protocol" {
+			continue
+		}
 		for _, v := range vs {
 			p = append(p, k...)
 			p = append(p, ": "...)
@@ -97,10 +222,47 @@ func Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header,
 	}
 	p = append(p, "\r\n"...)
 
+	if u.HandshakeTimeout > 0 {
+		netConn.SetWriteDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
 	if _, err = netConn.Write(p); err != nil {
 		netConn.Close()
 		return nil, err
 	}
+	if u.HandshakeTimeout > 0 {
+		netConn.SetWriteDeadline(time.Time{})
+	}
 
 	return c, nil
 }
+
+// Upgrade upgrades the HTTP server connection to the WebSocket protocol.
+//
+// Deprecated: Use Upgrader.Upgrade instead, which also supports origin
+// checking, subprotocol negotiation and automatic HTTP error responses.
+//
+// Upgrade returns a HandshakeError if the request is not a WebSocket
+// handshake. Applications should handle errors of this type by replying to the
+// client with an HTTP response.
+//
+// The application is responsible for checking the request origin before
+// calling Upgrade. An example implementation of the same origin policy is:
+//
+//	if req.Header.Get("Origin") != "http://"+req.Host {
+//		http.Error(w, "Origin not allowed", 403)
+//		return
+//	}
+//
+// Use the responseHeader to specify cookies (Set-Cookie) and the subprotocol
+// (Sec-WebSocket-Protocol).
+func Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header, readBufSize, writeBufSize int) (*Conn, error) {
+	u := Upgrader{ReadBufferSize: readBufSize, WriteBufferSize: writeBufSize}
+	u.Error = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		// don't return errors to maintain backwards compatibility
+	}
+	u.CheckOrigin = func(r *http.Request) bool {
+		// allow all connections by default
+		return true
+	}
+	return u.Upgrade(w, r, responseHeader)
+}
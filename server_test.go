@@ -0,0 +1,53 @@
+// Copyright 2016 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCheckSameOrigin(t *testing.T) {
+	cases := []struct {
+		name   string
+		origin string
+		host   string
+		want   bool
+	}{
+		{"no origin header", "", "example.com", true},
+		{"matching origin", "http://example.com", "example.com", true},
+		{"cross origin", "http://evil.com", "example.com", false},
+		{"matching origin with port", "https://example.com:443", "example.com:443", true},
+	}
+	for _, c := range cases {
+		r := &http.Request{Host: c.host, Header: http.Header{}}
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		if got := checkSameOrigin(r); got != c.want {
+			t.Errorf("%s: checkSameOrigin() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestUpgraderSelectSubprotocol(t *testing.T) {
+	u := &Upgrader{Subprotocols: []string{"v2", "v1"}}
+
+	r := &http.Request{Header: http.Header{"Sec-Websocket-Protocol": {"v1, v3"}}}
+	if got := u.selectSubprotocol(r); got != "v1" {
+		t.Fatalf("selectSubprotocol() = %q, want %q", got, "v1")
+	}
+
+	r2 := &http.Request{Header: http.Header{"Sec-Websocket-Protocol": {"v3"}}}
+	if got := u.selectSubprotocol(r2); got != "" {
+		t.Fatalf("selectSubprotocol() = %q, want empty", got)
+	}
+
+	noPrefs := &Upgrader{}
+	r3 := &http.Request{Header: http.Header{"Sec-Websocket-Protocol": {"v1"}}}
+	if got := noPrefs.selectSubprotocol(r3); got != "" {
+		t.Fatalf("selectSubprotocol() with no configured Subprotocols = %q, want empty", got)
+	}
+}